@@ -0,0 +1,42 @@
+package cloud
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/roachprod/vm"
+	"github.com/cockroachdb/roachprod/vm/gce"
+)
+
+// GarbageCollectClusters reclaims cloud resources (disks, static IPs, load
+// balancers, ...) that outlived the instances that referenced them. It is
+// meant to run alongside the existing hourly instance GC
+// (`roachprod gc --gce-project=...`), which only reaps instances themselves
+// and leaves anything detached from them to leak.
+//
+// Currently only the gce provider implements this; providers that don't are
+// silently skipped.
+func GarbageCollectClusters(dryRun bool) error {
+	p, ok := vm.Providers[gce.ProviderName].(*gce.Provider)
+	if !ok {
+		return nil
+	}
+
+	orphans, err := p.GarbageCollect(dryRun)
+	if err != nil {
+		return err
+	}
+
+	counts := map[string]int{}
+	for _, o := range orphans {
+		counts[o.Type]++
+	}
+
+	verb := "reclaimed"
+	if dryRun {
+		verb = "would reclaim"
+	}
+	for resourceType, n := range counts {
+		fmt.Printf("gce: %s %d orphaned %s(s)\n", verb, n, resourceType)
+	}
+	return nil
+}
@@ -0,0 +1,567 @@
+// Package azure implements the vm.Provider interface on top of the Azure
+// SDK for Go, mirroring the ephemeral-VM lifetime semantics of the gce
+// package (see vm/gce/gcloud.go) so that cloud.ListCloud and the GC path
+// work uniformly across providers.
+package azure
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2019-05-01/resources"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/cockroachdb/roachprod/config"
+	"github.com/cockroachdb/roachprod/vm"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// ProviderName is how this provider is registered into vm.Providers.
+	ProviderName = "azure"
+
+	defaultResourceGroup = "roachprod"
+	defaultSubscription  = ""
+
+	lifetimeTag = "lifetime"
+	userTag     = "user"
+	// createdTag records the Unix timestamp Create issued the VM at. The
+	// Azure VirtualMachine type (2019-07-01) doesn't surface its own
+	// creation time, so we stamp one ourselves, the same way lifetimeTag
+	// already carries the lifetime.
+	createdTag = "created"
+)
+
+// init will inject the Azure provider into vm.Providers.
+func init() {
+	vm.Providers[ProviderName] = &Provider{}
+}
+
+// newAuthorizer builds an autorest.Authorizer, preferring a service
+// principal configured via the standard AZURE_* environment variables and
+// falling back to whatever `az login` has cached locally.
+func newAuthorizer() (autorest.Authorizer, error) {
+	if a, err := auth.NewAuthorizerFromEnvironment(); err == nil {
+		return a, nil
+	}
+	a, err := auth.NewAuthorizerFromCLI()
+	if err != nil {
+		return nil, errors.Wrap(err, "no Azure credentials found; set AZURE_CLIENT_ID/AZURE_CLIENT_SECRET/"+
+			"AZURE_TENANT_ID or run `az login`")
+	}
+	return a, nil
+}
+
+func subscriptionID() string {
+	if id := os.Getenv("AZURE_SUBSCRIPTION_ID"); id != "" {
+		return id
+	}
+	return defaultSubscription
+}
+
+// User-configurable, provider-specific options
+type providerOpts struct {
+	ResourceGroup string
+	Locations     []string
+	MachineType   string
+}
+
+func (o *providerOpts) ConfigureCreateFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&o.MachineType, ProviderName+"-machine-type", "Standard_D4_v3",
+		"Machine type (see https://docs.microsoft.com/en-us/azure/virtual-machines/sizes)")
+	flags.StringSliceVar(&o.Locations, ProviderName+"-locations",
+		[]string{"eastus", "westus2", "westeurope"}, "Locations for cluster")
+}
+
+func (o *providerOpts) ConfigureClusterFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&o.ResourceGroup, ProviderName+"-resource-group", defaultResourceGroup,
+		"Resource group to create the cluster in")
+}
+
+type Provider struct {
+	opts providerOpts
+
+	authorizer autorest.Authorizer
+}
+
+func (p *Provider) Name() string {
+	return ProviderName
+}
+
+func (p *Provider) Flags() vm.ProviderFlags {
+	return &p.opts
+}
+
+func (p *Provider) authorize() (autorest.Authorizer, error) {
+	if p.authorizer != nil {
+		return p.authorizer, nil
+	}
+	a, err := newAuthorizer()
+	if err != nil {
+		return nil, err
+	}
+	p.authorizer = a
+	return a, nil
+}
+
+func (p *Provider) vmClient() (compute.VirtualMachinesClient, error) {
+	client := compute.NewVirtualMachinesClient(subscriptionID())
+	a, err := p.authorize()
+	if err != nil {
+		return client, err
+	}
+	client.Authorizer = a
+	return client, nil
+}
+
+func (p *Provider) nicClient() (network.InterfacesClient, error) {
+	client := network.NewInterfacesClient(subscriptionID())
+	a, err := p.authorize()
+	if err != nil {
+		return client, err
+	}
+	client.Authorizer = a
+	return client, nil
+}
+
+func (p *Provider) publicIPClient() (network.PublicIPAddressesClient, error) {
+	client := network.NewPublicIPAddressesClient(subscriptionID())
+	a, err := p.authorize()
+	if err != nil {
+		return client, err
+	}
+	client.Authorizer = a
+	return client, nil
+}
+
+func (p *Provider) vnetClient() (network.VirtualNetworksClient, error) {
+	client := network.NewVirtualNetworksClient(subscriptionID())
+	a, err := p.authorize()
+	if err != nil {
+		return client, err
+	}
+	client.Authorizer = a
+	return client, nil
+}
+
+func (p *Provider) subnetClient() (network.SubnetsClient, error) {
+	client := network.NewSubnetsClient(subscriptionID())
+	a, err := p.authorize()
+	if err != nil {
+		return client, err
+	}
+	client.Authorizer = a
+	return client, nil
+}
+
+func (p *Provider) groupsClient() (resources.GroupsClient, error) {
+	client := resources.NewGroupsClient(subscriptionID())
+	a, err := p.authorize()
+	if err != nil {
+		return client, err
+	}
+	client.Authorizer = a
+	return client, nil
+}
+
+// ensureResourceGroup makes sure the configured resource group exists in the
+// given location, creating it if necessary.
+func (p *Provider) ensureResourceGroup(ctx context.Context, location string) error {
+	client, err := p.groupsClient()
+	if err != nil {
+		return err
+	}
+	_, err = client.CreateOrUpdate(ctx, p.opts.ResourceGroup, resources.Group{
+		Location: to.StringPtr(location),
+	})
+	return err
+}
+
+// ensureNetwork makes sure a vnet/subnet named after the location exists,
+// returning the subnet to attach new NICs to.
+func (p *Provider) ensureNetwork(ctx context.Context, location string) (network.Subnet, error) {
+	vnets, err := p.vnetClient()
+	if err != nil {
+		return network.Subnet{}, err
+	}
+	vnetName := "roachprod-" + location
+
+	future, err := vnets.CreateOrUpdate(ctx, p.opts.ResourceGroup, vnetName, network.VirtualNetwork{
+		Location: to.StringPtr(location),
+		VirtualNetworkPropertiesFormat: &network.VirtualNetworkPropertiesFormat{
+			AddressSpace: &network.AddressSpace{
+				AddressPrefixes: &[]string{"10.0.0.0/16"},
+			},
+			Subnets: &[]network.Subnet{
+				{
+					Name: to.StringPtr("default"),
+					SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+						AddressPrefix: to.StringPtr("10.0.0.0/24"),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return network.Subnet{}, errors.Wrapf(err, "creating vnet %s", vnetName)
+	}
+	if err := future.WaitForCompletionRef(ctx, vnets.Client); err != nil {
+		return network.Subnet{}, errors.Wrapf(err, "waiting on vnet %s", vnetName)
+	}
+
+	subnets, err := p.subnetClient()
+	if err != nil {
+		return network.Subnet{}, err
+	}
+	return subnets.Get(ctx, p.opts.ResourceGroup, vnetName, "default", "")
+}
+
+// createNIC provisions a public IP and network interface for name, attached
+// to subnet.
+func (p *Provider) createNIC(
+	ctx context.Context, name, location string, subnet network.Subnet,
+) (network.Interface, error) {
+	publicIPs, err := p.publicIPClient()
+	if err != nil {
+		return network.Interface{}, err
+	}
+	ipFuture, err := publicIPs.CreateOrUpdate(ctx, p.opts.ResourceGroup, name+"-ip", network.PublicIPAddress{
+		Location: to.StringPtr(location),
+		PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{
+			PublicIPAllocationMethod: network.Static,
+			// Required so Azure actually assigns the
+			// "<name>.<location>.cloudapp.azure.com" hostname that
+			// instanceToVM reports as DNS; without it the address has no
+			// public DNS name and that hostname never resolves.
+			DNSSettings: &network.PublicIPAddressDNSSettings{
+				DomainNameLabel: to.StringPtr(name),
+			},
+		},
+	})
+	if err != nil {
+		return network.Interface{}, errors.Wrapf(err, "creating public IP for %s", name)
+	}
+	if err := ipFuture.WaitForCompletionRef(ctx, publicIPs.Client); err != nil {
+		return network.Interface{}, errors.Wrapf(err, "waiting on public IP for %s", name)
+	}
+	publicIP, err := ipFuture.Result(publicIPs)
+	if err != nil {
+		return network.Interface{}, err
+	}
+
+	nics, err := p.nicClient()
+	if err != nil {
+		return network.Interface{}, err
+	}
+	nicFuture, err := nics.CreateOrUpdate(ctx, p.opts.ResourceGroup, name+"-nic", network.Interface{
+		Location: to.StringPtr(location),
+		InterfacePropertiesFormat: &network.InterfacePropertiesFormat{
+			IPConfigurations: &[]network.InterfaceIPConfiguration{
+				{
+					Name: to.StringPtr("ipconfig1"),
+					InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+						Subnet:                    &subnet,
+						PrivateIPAllocationMethod: network.Dynamic,
+						PublicIPAddress:           &publicIP,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return network.Interface{}, errors.Wrapf(err, "creating NIC for %s", name)
+	}
+	if err := nicFuture.WaitForCompletionRef(ctx, nics.Client); err != nil {
+		return network.Interface{}, errors.Wrapf(err, "waiting on NIC for %s", name)
+	}
+	return nicFuture.Result(nics)
+}
+
+func (p *Provider) Create(names []string, opts vm.CreateOpts) error {
+	ctx := context.Background()
+
+	if len(p.opts.Locations) == 0 {
+		return errors.New("no locations configured")
+	}
+	if !opts.GeoDistributed {
+		p.opts.Locations = p.opts.Locations[:1]
+	}
+
+	// The resource group and each location's vnet/subnet are shared by every
+	// VM in the cluster, so provision them once up front rather than from
+	// inside each per-VM goroutine below. Concurrent CreateOrUpdate calls
+	// against the same ARM resource commonly fail with Azure's
+	// "AnotherOperationInProgress", and for a GeoDistributed create, the
+	// resource group would otherwise be PUT with a different Location from
+	// every location's goroutine, which conflicts since a resource group's
+	// location is fixed at creation.
+	if err := p.ensureResourceGroup(ctx, p.opts.Locations[0]); err != nil {
+		return errors.Wrapf(err, "creating resource group %s", p.opts.ResourceGroup)
+	}
+	subnets := make(map[string]network.Subnet, len(p.opts.Locations))
+	for _, location := range p.opts.Locations {
+		subnet, err := p.ensureNetwork(ctx, location)
+		if err != nil {
+			return err
+		}
+		subnets[location] = subnet
+	}
+
+	var g errgroup.Group
+	for i, name := range names {
+		name := name
+		location := p.opts.Locations[i%len(p.opts.Locations)]
+		subnet := subnets[location]
+
+		g.Go(func() error {
+			nic, err := p.createNIC(ctx, name, location, subnet)
+			if err != nil {
+				return err
+			}
+
+			client, err := p.vmClient()
+			if err != nil {
+				return err
+			}
+
+			future, err := client.CreateOrUpdate(ctx, p.opts.ResourceGroup, name, compute.VirtualMachine{
+				Location: to.StringPtr(location),
+				Tags: map[string]*string{
+					lifetimeTag: to.StringPtr(opts.Lifetime.String()),
+					userTag:     to.StringPtr(config.OSUser.Username),
+					createdTag:  to.StringPtr(strconv.FormatInt(time.Now().Unix(), 10)),
+				},
+				VirtualMachineProperties: &compute.VirtualMachineProperties{
+					HardwareProfile: &compute.HardwareProfile{
+						VMSize: compute.VirtualMachineSizeTypes(p.opts.MachineType),
+					},
+					StorageProfile: &compute.StorageProfile{
+						ImageReference: &compute.ImageReference{
+							Publisher: to.StringPtr("Canonical"),
+							Offer:     to.StringPtr("UbuntuServer"),
+							Sku:       to.StringPtr("16.04-LTS"),
+							Version:   to.StringPtr("latest"),
+						},
+					},
+					OsProfile: &compute.OSProfile{
+						ComputerName:  to.StringPtr(name),
+						AdminUsername: to.StringPtr(config.OSUser.Username),
+					},
+					NetworkProfile: &compute.NetworkProfile{
+						NetworkInterfaces: &[]compute.NetworkInterfaceReference{
+							{ID: nic.ID},
+						},
+					},
+				},
+			})
+			if err != nil {
+				return errors.Wrapf(err, "creating instance %s", name)
+			}
+			if err := future.WaitForCompletionRef(ctx, client.Client); err != nil {
+				return errors.Wrapf(err, "waiting on instance %s", name)
+			}
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
+
+func (p *Provider) Delete(vms vm.List) error {
+	ctx := context.Background()
+	client, err := p.vmClient()
+	if err != nil {
+		return err
+	}
+
+	var g errgroup.Group
+	for _, v := range vms {
+		if v.Provider != ProviderName {
+			return errors.Errorf("%s received VM instance from %s", ProviderName, v.Provider)
+		}
+		v := v
+		g.Go(func() error {
+			future, err := client.Delete(ctx, p.opts.ResourceGroup, v.Name)
+			if err != nil {
+				return errors.Wrapf(err, "deleting instance %s", v.Name)
+			}
+			return future.WaitForCompletionRef(ctx, client.Client)
+		})
+	}
+	return g.Wait()
+}
+
+func (p *Provider) Extend(vms vm.List, lifetime time.Duration) error {
+	ctx := context.Background()
+	client, err := p.vmClient()
+	if err != nil {
+		return err
+	}
+
+	for _, v := range vms {
+		instance, err := client.Get(ctx, p.opts.ResourceGroup, v.Name, "")
+		if err != nil {
+			return errors.Wrapf(err, "fetching instance %s", v.Name)
+		}
+		if instance.Tags == nil {
+			instance.Tags = map[string]*string{}
+		}
+		instance.Tags[lifetimeTag] = to.StringPtr(lifetime.String())
+
+		future, err := client.Update(ctx, p.opts.ResourceGroup, v.Name, compute.VirtualMachineUpdate{
+			Tags: instance.Tags,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "extending instance %s", v.Name)
+		}
+		if err := future.WaitForCompletionRef(ctx, client.Client); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List enumerates the VM instances in p.opts.ResourceGroup; other resource
+// groups are not searched.
+func (p *Provider) List() (vm.List, error) {
+	ctx := context.Background()
+	client, err := p.vmClient()
+	if err != nil {
+		return nil, err
+	}
+	nics, err := p.nicClient()
+	if err != nil {
+		return nil, err
+	}
+	publicIPs, err := p.publicIPClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var vms vm.List
+	for page, err := client.List(ctx, p.opts.ResourceGroup); ; {
+		if err != nil {
+			return nil, errors.Wrap(err, "listing instances")
+		}
+		for _, instance := range page.Values() {
+			v, err := p.instanceToVM(ctx, instance, nics, publicIPs)
+			if err != nil {
+				v = vm.VM{Name: *instance.Name, Provider: ProviderName, Errors: []error{err}}
+			}
+			vms = append(vms, v)
+		}
+		if !page.NotDone() {
+			break
+		}
+		err = page.NextWithContext(ctx)
+	}
+
+	return vms, nil
+}
+
+func (p *Provider) instanceToVM(
+	ctx context.Context,
+	instance compute.VirtualMachine,
+	nics network.InterfacesClient,
+	publicIPs network.PublicIPAddressesClient,
+) (vm.VM, error) {
+	var vmErrors []error
+
+	var lifetime time.Duration
+	if instance.Tags != nil && instance.Tags[lifetimeTag] != nil {
+		var err error
+		if lifetime, err = time.ParseDuration(*instance.Tags[lifetimeTag]); err != nil {
+			vmErrors = append(vmErrors, vm.ErrNoExpiration)
+		}
+	} else {
+		vmErrors = append(vmErrors, vm.ErrNoExpiration)
+	}
+
+	var createdAt time.Time
+	if instance.Tags != nil && instance.Tags[createdTag] != nil {
+		if secs, err := strconv.ParseInt(*instance.Tags[createdTag], 10, 64); err == nil {
+			createdAt = time.Unix(secs, 0)
+		}
+	}
+
+	var privateIP, publicIP string
+	if instance.NetworkProfile != nil && instance.NetworkProfile.NetworkInterfaces != nil &&
+		len(*instance.NetworkProfile.NetworkInterfaces) > 0 {
+		nicRef := (*instance.NetworkProfile.NetworkInterfaces)[0]
+		nicName := lastComponent(*nicRef.ID)
+		nic, err := nics.Get(ctx, p.opts.ResourceGroup, nicName, "")
+		if err == nil && nic.IPConfigurations != nil && len(*nic.IPConfigurations) > 0 {
+			ipConfig := (*nic.IPConfigurations)[0]
+			if ipConfig.PrivateIPAddress != nil {
+				privateIP = *ipConfig.PrivateIPAddress
+			}
+			if ipConfig.PublicIPAddress != nil && ipConfig.PublicIPAddress.ID != nil {
+				pipName := lastComponent(*ipConfig.PublicIPAddress.ID)
+				if pip, err := publicIPs.Get(ctx, p.opts.ResourceGroup, pipName, ""); err == nil &&
+					pip.IPAddress != nil {
+					publicIP = *pip.IPAddress
+				}
+			}
+		}
+	}
+	if publicIP == "" || privateIP == "" {
+		vmErrors = append(vmErrors, vm.ErrBadNetwork)
+	}
+
+	var machineType string
+	if instance.HardwareProfile != nil {
+		machineType = string(instance.HardwareProfile.VMSize)
+	}
+
+	return vm.VM{
+		Name:        *instance.Name,
+		CreatedAt:   createdAt,
+		Errors:      vmErrors,
+		DNS:         fmt.Sprintf("%s.%s.cloudapp.azure.com", *instance.Name, to.String(instance.Location)),
+		Lifetime:    lifetime,
+		PrivateIP:   privateIP,
+		Provider:    ProviderName,
+		ProviderID:  *instance.Name,
+		PublicIP:    publicIP,
+		RemoteUser:  config.OSUser.Username,
+		Zone:        to.String(instance.Location),
+		MachineType: machineType,
+	}, nil
+}
+
+// lastComponent returns the last segment of an Azure resource ID, e.g.
+// ".../networkInterfaces/roachprod-0001-nic" -> "roachprod-0001-nic".
+func lastComponent(id string) string {
+	parts := strings.Split(id, "/")
+	return parts[len(parts)-1]
+}
+
+// FindActiveAccount reports the account the configured credentials will
+// authenticate as. Azure service principals don't carry a roachprod-style
+// username, so we fall back to the local OS user, same as gce.Provider does
+// for the RemoteUser field.
+func (p *Provider) FindActiveAccount() (string, error) {
+	if _, err := p.authorize(); err != nil {
+		return "", err
+	}
+	return config.OSUser.Username, nil
+}
+
+// ConfigSSH is a no-op: roachprod addresses Azure VMs directly by their
+// public IP, the same way it does for every other non-local provider.
+func (p *Provider) ConfigSSH() error {
+	return nil
+}
+
+func (p *Provider) CleanSSH() error {
+	return nil
+}
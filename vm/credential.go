@@ -0,0 +1,21 @@
+package vm
+
+import (
+	"context"
+
+	"golang.org/x/oauth2"
+)
+
+// CredentialProvider abstracts how a Provider authenticates to its cloud
+// API, so that a single provider implementation can support multiple
+// credential sources (application-default credentials, a key file, a
+// Vault-backed secret, ...) behind one seam, and so that future providers
+// (Azure MSI, AWS STS) can reuse it instead of inventing their own.
+type CredentialProvider interface {
+	// Token returns a valid OAuth2 token, refreshing or re-fetching it as
+	// necessary.
+	Token(ctx context.Context) (*oauth2.Token, error)
+	// Account returns the identity (typically an email address) this
+	// provider authenticates as.
+	Account() string
+}
@@ -0,0 +1,222 @@
+package gce
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/cockroachdb/roachprod/vm"
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// userInfoEndpoint reports the identity behind an access token. It's used as
+// a last resort by credential providers whose token source doesn't otherwise
+// expose an email: an ADC "authorized_user" refresh token (the JSON produced
+// by `gcloud auth application-default login`, which has no client_email
+// field) and a Vault-issued GCP access token (the token-roleset response,
+// which has no email field either).
+const userInfoEndpoint = "https://www.googleapis.com/oauth2/v2/userinfo"
+
+// lookupAccountEmail asks the userinfo endpoint which account an access
+// token belongs to. It returns "" (never an error) on any failure, since
+// callers treat a missing account as something to report via
+// FindActiveAccount rather than fail the whole credential provider on.
+func lookupAccountEmail(ctx context.Context, ts oauth2.TokenSource) string {
+	client := oauth2.NewClient(ctx, ts)
+	resp, err := client.Get(userInfoEndpoint)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+
+	var userInfo struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
+		return ""
+	}
+	return userInfo.Email
+}
+
+// credentialTokenSource adapts a vm.CredentialProvider to an
+// oauth2.TokenSource so it can back an *http.Client.
+type credentialTokenSource struct {
+	ctx context.Context
+	cp  vm.CredentialProvider
+}
+
+func (t credentialTokenSource) Token() (*oauth2.Token, error) {
+	return t.cp.Token(t.ctx)
+}
+
+// newCredentialProvider selects a vm.CredentialProvider based on the
+// provider's configured flags: a Vault path takes precedence over an
+// explicit key file, which takes precedence over application-default
+// credentials.
+func newCredentialProvider(ctx context.Context, opts providerOpts) (vm.CredentialProvider, error) {
+	switch {
+	case opts.VaultPath != "":
+		return newVaultCredentialProvider(opts.VaultPath)
+	case opts.CredentialsFile != "":
+		return newKeyFileCredentialProvider(ctx, opts.CredentialsFile)
+	default:
+		return newADCCredentialProvider(ctx)
+	}
+}
+
+// adcCredentialProvider authenticates with whatever google.
+// FindDefaultCredentials turns up: the GOOGLE_APPLICATION_CREDENTIALS file,
+// gcloud's cached application-default login, or (on GCE) the metadata
+// server.
+type adcCredentialProvider struct {
+	creds *google.Credentials
+}
+
+func newADCCredentialProvider(ctx context.Context) (*adcCredentialProvider, error) {
+	creds, err := google.FindDefaultCredentials(ctx, compute.ComputeScope)
+	if err != nil {
+		return nil, errors.Wrap(err,
+			"no application-default credentials found; run `gcloud auth application-default login`")
+	}
+	return &adcCredentialProvider{creds: creds}, nil
+}
+
+func (c *adcCredentialProvider) Token(context.Context) (*oauth2.Token, error) {
+	return c.creds.TokenSource.Token()
+}
+
+func (c *adcCredentialProvider) Account() string {
+	if metadata.OnGCE() {
+		if email, err := metadata.Email("default"); err == nil {
+			return email
+		}
+	}
+	var keyFile struct {
+		ClientEmail string `json:"client_email"`
+	}
+	if json.Unmarshal(c.creds.JSON, &keyFile) == nil && keyFile.ClientEmail != "" {
+		return keyFile.ClientEmail
+	}
+	// Not on GCE and not a service account key, so this is almost certainly
+	// an "authorized_user" file from `gcloud auth application-default
+	// login`, which carries no email of its own. Ask Google directly.
+	return lookupAccountEmail(context.Background(), c.creds.TokenSource)
+}
+
+// keyFileCredentialProvider authenticates with a service account JSON key
+// read from an explicit path (--gce-credentials-file).
+type keyFileCredentialProvider struct {
+	creds *google.Credentials
+	email string
+}
+
+func newKeyFileCredentialProvider(ctx context.Context, path string) (*keyFileCredentialProvider, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", path)
+	}
+	creds, err := google.CredentialsFromJSON(ctx, raw, compute.ComputeScope)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse credentials in %s", path)
+	}
+
+	var keyFile struct {
+		ClientEmail string `json:"client_email"`
+	}
+	// Best-effort: if the file isn't a service account key (e.g. it's a
+	// refresh-token-style ADC file), we just won't have an Account().
+	_ = json.Unmarshal(raw, &keyFile)
+
+	return &keyFileCredentialProvider{creds: creds, email: keyFile.ClientEmail}, nil
+}
+
+func (c *keyFileCredentialProvider) Token(context.Context) (*oauth2.Token, error) {
+	return c.creds.TokenSource.Token()
+}
+
+func (c *keyFileCredentialProvider) Account() string {
+	return c.email
+}
+
+// vaultCredentialProvider reads an OAuth2 token from a Vault KV or GCP
+// secrets engine path (--gce-vault-path) on every refresh, rather than
+// caching one locally. This lets CI inject short-lived tokens without ever
+// writing a key file to disk; Vault connection details (VAULT_ADDR,
+// VAULT_TOKEN, ...) come from the standard Vault environment variables.
+type vaultCredentialProvider struct {
+	client  *vaultapi.Client
+	path    string
+	account string
+}
+
+func newVaultCredentialProvider(path string) (*vaultCredentialProvider, error) {
+	cfg := vaultapi.DefaultConfig()
+	if err := cfg.ReadEnvironment(); err != nil {
+		return nil, errors.Wrap(err, "reading Vault environment (VAULT_ADDR/VAULT_TOKEN)")
+	}
+	client, err := vaultapi.NewClient(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating Vault client")
+	}
+	return &vaultCredentialProvider{client: client, path: path}, nil
+}
+
+func (c *vaultCredentialProvider) Token(context.Context) (*oauth2.Token, error) {
+	secret, err := c.client.Logical().Read(c.path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading Vault secret %s", c.path)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, errors.Errorf("no data at Vault path %s", c.path)
+	}
+
+	tokenStr, ok := secret.Data["token"].(string)
+	if !ok {
+		// The GCP secrets engine's token rolesets store the access token
+		// under this key instead.
+		tokenStr, ok = secret.Data["access_token"].(string)
+	}
+	if !ok || tokenStr == "" {
+		return nil, errors.Errorf("Vault secret %s has no token/access_token field", c.path)
+	}
+
+	expiry := time.Now().Add(time.Hour)
+	if secs, ok := secret.Data["expires_at_seconds"].(json.Number); ok {
+		if n, err := secs.Int64(); err == nil {
+			expiry = time.Unix(n, 0)
+		}
+	} else if secret.LeaseDuration > 0 {
+		expiry = time.Now().Add(time.Duration(secret.LeaseDuration) * time.Second)
+	}
+
+	if email, ok := secret.Data["email"].(string); ok {
+		c.account = email
+	}
+
+	return &oauth2.Token{AccessToken: tokenStr, TokenType: "Bearer", Expiry: expiry}, nil
+}
+
+func (c *vaultCredentialProvider) Account() string {
+	if c.account != "" {
+		return c.account
+	}
+	// The GCP secrets engine's token rolesets don't return an email
+	// alongside the access token, so Token() will have left c.account
+	// empty; ask the userinfo endpoint which account the token is for.
+	token, err := c.Token(context.Background())
+	if err != nil {
+		return ""
+	}
+	c.account = lookupAccountEmail(context.Background(), oauth2.StaticTokenSource(token))
+	return c.account
+}
@@ -0,0 +1,284 @@
+package gce
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// orphanMaxAge bounds how long a resource with no "lifetime" label of its
+// own may sit around before GarbageCollect considers it abandoned rather
+// than mid-create.
+const orphanMaxAge = 24 * time.Hour
+
+// Orphan describes a cloud resource that is no longer referenced by any live
+// instance and that GarbageCollect considers safe to reclaim.
+type Orphan struct {
+	// Type is one of "disk", "address", "forwarding-rule", or "target-pool".
+	Type string
+	// Name is the resource's name.
+	Name string
+	// Location is the zone (for disks) or region (for addresses, forwarding
+	// rules, and target pools) the resource lives in.
+	Location string
+}
+
+// GarbageCollect reclaims disks, static IPs, and load balancer resources
+// (forwarding rules and target pools) that were detached from, or otherwise
+// outlived, the instances that created them. Unlike Delete, which only tears
+// down the instances belonging to a specific cluster, GarbageCollect scans
+// the whole project; it's meant to run on a schedule (see
+// cloud.GarbageCollectClusters) alongside the existing hourly instance GC,
+// rather than as part of destroying a specific cluster.
+//
+// When dryRun is true, nothing is deleted; the returned Orphans describe
+// what would have been reclaimed.
+func (p *Provider) GarbageCollect(dryRun bool) ([]Orphan, error) {
+	ctx := context.Background()
+	service, err := p.computeService()
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []Orphan
+
+	disks, err := p.orphanedDisks(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	orphans = append(orphans, disks...)
+
+	addrs, err := p.orphanedAddresses(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	orphans = append(orphans, addrs...)
+
+	lbs, err := p.orphanedLoadBalancers(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	orphans = append(orphans, lbs...)
+
+	if dryRun {
+		for _, o := range orphans {
+			fmt.Printf("gce: would delete orphaned %s %s (%s)\n", o.Type, o.Name, o.Location)
+		}
+		return orphans, nil
+	}
+
+	var reclaimed []Orphan
+	for _, o := range orphans {
+		if err := p.deleteOrphan(service, o); err != nil {
+			fmt.Printf("gce: failed to delete orphaned %s %s: %s\n", o.Type, o.Name, err)
+			continue
+		}
+		reclaimed = append(reclaimed, o)
+	}
+	return reclaimed, nil
+}
+
+func (p *Provider) deleteOrphan(service *compute.Service, o Orphan) error {
+	switch o.Type {
+	case "disk":
+		return withRetry(func() error {
+			op, err := service.Disks.Delete(p.opts.Project, o.Location, o.Name).Do()
+			if err != nil {
+				return err
+			}
+			return waitForZoneOp(service, p.opts.Project, o.Location, op)
+		})
+	case "address":
+		return withRetry(func() error {
+			_, err := service.Addresses.Delete(p.opts.Project, o.Location, o.Name).Do()
+			return err
+		})
+	case "forwarding-rule":
+		return withRetry(func() error {
+			_, err := service.ForwardingRules.Delete(p.opts.Project, o.Location, o.Name).Do()
+			return err
+		})
+	case "target-pool":
+		return withRetry(func() error {
+			_, err := service.TargetPools.Delete(p.opts.Project, o.Location, o.Name).Do()
+			return err
+		})
+	default:
+		return errors.Errorf("unknown orphan type %q", o.Type)
+	}
+}
+
+// resourceOlderThan reports whether a resource created at creationTimestamp
+// (an RFC3339 string, as returned by the Compute API for every resource
+// type) has outlived its "lifetime" label, or orphanMaxAge if it has none.
+// It's deliberately conservative: a malformed or missing timestamp is
+// treated as "not yet expired" rather than risking deletion of something
+// that was just created.
+func resourceOlderThan(creationTimestamp string, labels map[string]string) bool {
+	created, err := time.Parse(time.RFC3339, creationTimestamp)
+	if err != nil {
+		return false
+	}
+
+	maxAge := orphanMaxAge
+	if lifetimeStr, ok := labels["lifetime"]; ok {
+		if d, err := time.ParseDuration(lifetimeStr); err == nil {
+			maxAge = d
+		}
+	}
+	return time.Since(created) > maxAge
+}
+
+// diskExpired reports whether a detached disk has outlived its "lifetime"
+// label (or orphanMaxAge, if it has none).
+func diskExpired(disk *compute.Disk) bool {
+	return resourceOlderThan(disk.CreationTimestamp, disk.Labels)
+}
+
+// orphanedDisks finds zonal disks with no attached instance that have
+// outlived their lifetime.
+func (p *Provider) orphanedDisks(ctx context.Context, service *compute.Service) ([]Orphan, error) {
+	var orphans []Orphan
+	err := service.Zones.List(p.opts.Project).Pages(ctx, func(zones *compute.ZoneList) error {
+		for _, zone := range zones.Items {
+			zone := zone
+			err := service.Disks.List(p.opts.Project, zone.Name).Pages(ctx, func(disks *compute.DiskList) error {
+				for _, disk := range disks.Items {
+					if len(disk.Users) > 0 || !diskExpired(disk) {
+						continue
+					}
+					orphans = append(orphans, Orphan{Type: "disk", Name: disk.Name, Location: zone.Name})
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "listing disks")
+	}
+	return orphans, nil
+}
+
+// orphanedAddresses finds regional static IPs that were reserved but never
+// (or no longer) attached to anything, and have outlived orphanMaxAge (or
+// their own "lifetime" label) the same way a detached disk must.
+func (p *Provider) orphanedAddresses(ctx context.Context, service *compute.Service) ([]Orphan, error) {
+	var orphans []Orphan
+	err := service.Regions.List(p.opts.Project).Pages(ctx, func(regions *compute.RegionList) error {
+		for _, region := range regions.Items {
+			region := region
+			err := service.Addresses.List(p.opts.Project, region.Name).Pages(ctx, func(addrs *compute.AddressList) error {
+				for _, addr := range addrs.Items {
+					if addr.Status != "RESERVED" || !resourceOlderThan(addr.CreationTimestamp, addr.Labels) {
+						continue
+					}
+					orphans = append(orphans, Orphan{Type: "address", Name: addr.Name, Location: region.Name})
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "listing addresses")
+	}
+	return orphans, nil
+}
+
+// orphanedLoadBalancers finds regional target pools whose backend instances
+// have all been deleted, plus any forwarding rules that still point at them.
+// As with orphanedDisks, a pool or rule only counts once it has outlived
+// orphanMaxAge (or its own "lifetime" label), so one that's merely mid-resize
+// isn't mistaken for abandoned.
+func (p *Provider) orphanedLoadBalancers(ctx context.Context, service *compute.Service) ([]Orphan, error) {
+	var orphans []Orphan
+	orphanedPools := map[string]bool{} // target pool self-link -> has no live backends
+
+	err := service.Regions.List(p.opts.Project).Pages(ctx, func(regions *compute.RegionList) error {
+		for _, region := range regions.Items {
+			region := region
+
+			err := service.TargetPools.List(p.opts.Project, region.Name).Pages(ctx,
+				func(pools *compute.TargetPoolList) error {
+					for _, pool := range pools.Items {
+						if p.targetPoolHasLiveBackend(service, pool) {
+							continue
+						}
+						// A pool that just lost its last backend (e.g. mid-resize,
+						// or it was created before its backend was attached) isn't
+						// an orphan yet; give it the same grace period as a
+						// detached disk before reclaiming it.
+						if !resourceOlderThan(pool.CreationTimestamp, pool.Labels) {
+							continue
+						}
+						orphanedPools[pool.SelfLink] = true
+						orphans = append(orphans, Orphan{Type: "target-pool", Name: pool.Name, Location: region.Name})
+					}
+					return nil
+				})
+			if err != nil {
+				return err
+			}
+
+			err = service.ForwardingRules.List(p.opts.Project, region.Name).Pages(ctx,
+				func(rules *compute.ForwardingRuleList) error {
+					for _, rule := range rules.Items {
+						if !orphanedPools[rule.Target] {
+							continue
+						}
+						if !resourceOlderThan(rule.CreationTimestamp, rule.Labels) {
+							continue
+						}
+						orphans = append(orphans, Orphan{Type: "forwarding-rule", Name: rule.Name, Location: region.Name})
+					}
+					return nil
+				})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "listing load balancer resources")
+	}
+	return orphans, nil
+}
+
+func (p *Provider) targetPoolHasLiveBackend(service *compute.Service, pool *compute.TargetPool) bool {
+	for _, instanceURL := range pool.Instances {
+		zone, name := instanceFromURL(instanceURL)
+		if zone == "" {
+			continue
+		}
+		if _, err := service.Instances.Get(p.opts.Project, zone, name).Do(); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// instanceFromURL extracts the zone and instance name from a Compute Engine
+// instance URL, e.g.
+//  ".../projects/p/zones/us-east1-b/instances/foo" -> ("us-east1-b", "foo")
+func instanceFromURL(url string) (zone, name string) {
+	parts := strings.Split(url, "/")
+	name = parts[len(parts)-1]
+	for i, part := range parts {
+		if part == "zones" && i+1 < len(parts) {
+			zone = parts[i+1]
+		}
+	}
+	return zone, name
+}
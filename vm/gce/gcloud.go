@@ -2,21 +2,28 @@ package gce
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"math"
 	"os"
 	"os/exec"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cockroachdb/roachprod/config"
 	"github.com/cockroachdb/roachprod/vm"
 	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
+	"golang.org/x/oauth2"
 	"golang.org/x/sync/errgroup"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
 )
 
 const (
@@ -26,10 +33,55 @@ const (
 
 // init will inject the GCE provider into vm.Providers, but only if the gcloud tool is available on the local path.
 func init() {
-	if _, err := exec.LookPath("gcloud"); err == nil {
-		vm.Providers[ProviderName] = &Provider{}
-	} else {
-		log.Printf("please install the gcloud CLI utilities (https://cloud.google.com/sdk/downloads)")
+	if _, err := exec.LookPath("gcloud"); err != nil {
+		log.Printf("gcloud CLI not found: ConfigSSH/CleanSSH and --gce-use-gcloud will be unavailable")
+	}
+	vm.Providers[ProviderName] = &Provider{}
+}
+
+// isRetriableError reports whether err is a transient Compute Engine API
+// error worth retrying: 429 (rate limited) and 5xx (backend) responses.
+func isRetriableError(err error) bool {
+	if apiErr, ok := err.(*googleapi.Error); ok {
+		return apiErr.Code == 429 || apiErr.Code >= 500
+	}
+	return false
+}
+
+// withRetry invokes fn, retrying with exponential backoff on retriable
+// Compute Engine API errors.
+func withRetry(fn func() error) error {
+	backoff := 500 * time.Millisecond
+	var err error
+	for attempt := 0; attempt < 5; attempt++ {
+		if err = fn(); err == nil || !isRetriableError(err) {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	return err
+}
+
+// waitForZoneOp blocks until the given zonal operation completes, returning
+// an error if the operation itself failed.
+func waitForZoneOp(service *compute.Service, project, zone string, op *compute.Operation) error {
+	for {
+		var err error
+		if err = withRetry(func() error {
+			var waitErr error
+			op, waitErr = service.ZoneOperations.Wait(project, zone, op.Name).Do()
+			return waitErr
+		}); err != nil {
+			return errors.Wrapf(err, "waiting on operation %s", op.Name)
+		}
+		if op.Status != "DONE" {
+			continue
+		}
+		if op.Error != nil && len(op.Error.Errors) > 0 {
+			return errors.Errorf("operation %s failed: %s", op.Name, op.Error.Errors[0].Message)
+		}
+		return nil
 	}
 }
 
@@ -56,31 +108,20 @@ func runJSONCommand(args []string, parsed interface{}) error {
 	return nil
 }
 
-// Used to parse the gcloud responses
-type jsonVM struct {
-	Name              string
-	Labels            map[string]string
-	CreationTimestamp time.Time
-	NetworkInterfaces []struct {
-		Network       string
-		NetworkIP     string
-		AccessConfigs []struct {
-			Name  string
-			NatIP string
-		}
-	}
-	MachineType string
-	Zone        string
+// stringPtr returns a pointer to s, for populating optional *string fields on
+// Compute Engine API request types.
+func stringPtr(s string) *string {
+	return &s
 }
 
-// Convert the JSON VM data into our common VM type
-func (jsonVM *jsonVM) toVM(project string) *vm.VM {
+// instanceToVM converts a Compute Engine instance into our common VM type.
+func instanceToVM(project string, instance *compute.Instance) *vm.VM {
 	var vmErrors []error
-	var err error
 
 	// Check "lifetime" label.
 	var lifetime time.Duration
-	if lifetimeStr, ok := jsonVM.Labels["lifetime"]; ok {
+	if lifetimeStr, ok := instance.Labels["lifetime"]; ok {
+		var err error
 		if lifetime, err = time.ParseDuration(lifetimeStr); err != nil {
 			vmErrors = append(vmErrors, vm.ErrNoExpiration)
 		}
@@ -89,7 +130,8 @@ func (jsonVM *jsonVM) toVM(project string) *vm.VM {
 	}
 
 	// lastComponent splits a url path and returns only the last part. This is
-	// used because some of the fields in jsonVM are defined using URLs like:
+	// used because some of the fields on compute.Instance are defined using
+	// URLs like:
 	//  "https://www.googleapis.com/compute/v1/projects/cockroach-shared/zones/us-east1-b/machineTypes/n1-standard-16"
 	// We want to strip this down to "n1-standard-16", so we only want the last
 	// component.
@@ -100,30 +142,34 @@ func (jsonVM *jsonVM) toVM(project string) *vm.VM {
 
 	// Extract network information
 	var publicIP, privateIP, vpc string
-	if len(jsonVM.NetworkInterfaces) == 0 {
+	if len(instance.NetworkInterfaces) == 0 {
 		vmErrors = append(vmErrors, vm.ErrBadNetwork)
 	} else {
-		privateIP = jsonVM.NetworkInterfaces[0].NetworkIP
-		if len(jsonVM.NetworkInterfaces[0].AccessConfigs) == 0 {
+		iface := instance.NetworkInterfaces[0]
+		privateIP = iface.NetworkIP
+		if len(iface.AccessConfigs) == 0 {
 			vmErrors = append(vmErrors, vm.ErrBadNetwork)
 		} else {
-			publicIP = jsonVM.NetworkInterfaces[0].AccessConfigs[0].NatIP
-			vpc = lastComponent(jsonVM.NetworkInterfaces[0].Network)
+			publicIP = iface.AccessConfigs[0].NatIP
+			vpc = lastComponent(iface.Network)
 		}
 	}
 
-	machineType := lastComponent(jsonVM.MachineType)
-	zone := lastComponent(jsonVM.Zone)
+	machineType := lastComponent(instance.MachineType)
+	zone := lastComponent(instance.Zone)
+
+	// The API hands back RFC3339 timestamps rather than time.Time.
+	createdAt, _ := time.Parse(time.RFC3339, instance.CreationTimestamp)
 
 	return &vm.VM{
-		Name:       jsonVM.Name,
-		CreatedAt:  jsonVM.CreationTimestamp,
+		Name:       instance.Name,
+		CreatedAt:  createdAt,
 		Errors:     vmErrors,
-		DNS:        fmt.Sprintf("%s.%s.%s", jsonVM.Name, zone, project),
+		DNS:        fmt.Sprintf("%s.%s.%s", instance.Name, zone, project),
 		Lifetime:   lifetime,
 		PrivateIP:  privateIP,
 		Provider:   ProviderName,
-		ProviderID: jsonVM.Name,
+		ProviderID: instance.Name,
 		PublicIP:   publicIP,
 		// N.B. gcloud uses the local username to log into instances rather
 		// than the username on the authenticated Google account.
@@ -145,6 +191,25 @@ type providerOpts struct {
 	ServiceAccount string
 	MachineType    string
 	Zones          []string
+
+	// UseGCloud forces the provider to shell out to the gcloud CLI instead of
+	// talking to the Compute Engine API directly. This is a last-resort
+	// fallback for environments where API credentials aren't set up, or
+	// where gcloud's behavior is otherwise needed.
+	UseGCloud bool
+
+	// zonesFlag is kept so that List can tell whether --gce-zones was set
+	// explicitly, as opposed to just holding its Create-time default.
+	zonesFlag *pflag.Flag
+
+	// CredentialsFile, if set, names a service account JSON key to
+	// authenticate with, taking precedence over application-default
+	// credentials.
+	CredentialsFile string
+	// VaultPath, if set, names a Vault KV or GCP-secrets-engine path to read
+	// an OAuth2 token from on every refresh. Takes precedence over
+	// CredentialsFile.
+	VaultPath string
 }
 
 func (o *providerOpts) ConfigureCreateFlags(flags *pflag.FlagSet) {
@@ -159,6 +224,9 @@ func (o *providerOpts) ConfigureCreateFlags(flags *pflag.FlagSet) {
 		"Machine type (see https://cloud.google.com/compute/docs/machine-types)")
 	flags.StringSliceVar(&o.Zones, ProviderName+"-zones",
 		[]string{"us-east1-b", "us-west1-b", "europe-west2-b"}, "Zones for cluster")
+	o.zonesFlag = flags.Lookup(ProviderName + "-zones")
+	flags.BoolVar(&o.UseGCloud, ProviderName+"-use-gcloud", false,
+		"Use the gcloud CLI instead of the Compute Engine API (fallback)")
 }
 
 func (o *providerOpts) ConfigureClusterFlags(flags *pflag.FlagSet) {
@@ -168,10 +236,59 @@ func (o *providerOpts) ConfigureClusterFlags(flags *pflag.FlagSet) {
 	}
 	flags.StringVar(&o.Project, ProviderName+"-project", project,
 		"Project to create cluster in")
+	flags.StringVar(&o.CredentialsFile, ProviderName+"-credentials-file", "",
+		"Path to a service account JSON key file to authenticate with "+
+			"(default: application-default credentials)")
+	flags.StringVar(&o.VaultPath, ProviderName+"-vault-path", "",
+		"Vault KV or GCP-secrets-engine path to read an OAuth2 token from on every refresh "+
+			"(takes precedence over "+ProviderName+"-credentials-file)")
 }
 
 type Provider struct {
 	opts providerOpts
+
+	// service is the lazily-initialized Compute Engine API client. Use
+	// computeService() rather than referencing this directly.
+	service *compute.Service
+	// credProvider is the lazily-selected vm.CredentialProvider backing
+	// service. Use credentials() rather than referencing this directly.
+	credProvider vm.CredentialProvider
+}
+
+// credentials returns the vm.CredentialProvider selected by --gce-vault-path
+// / --gce-credentials-file / application-default credentials (in that order
+// of precedence), creating it on first use.
+func (p *Provider) credentials() (vm.CredentialProvider, error) {
+	if p.credProvider != nil {
+		return p.credProvider, nil
+	}
+	cp, err := newCredentialProvider(context.Background(), p.opts)
+	if err != nil {
+		return nil, err
+	}
+	p.credProvider = cp
+	return cp, nil
+}
+
+// computeService returns an authenticated Compute Engine API client, creating
+// one on first use.
+func (p *Provider) computeService() (*compute.Service, error) {
+	if p.service != nil {
+		return p.service, nil
+	}
+
+	cp, err := p.credentials()
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	client := oauth2.NewClient(ctx, oauth2.ReuseTokenSource(nil, credentialTokenSource{ctx: ctx, cp: cp}))
+	service, err := compute.New(client)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create Compute Engine client")
+	}
+	p.service = service
+	return service, nil
 }
 
 func (p *Provider) CleanSSH() error {
@@ -185,6 +302,9 @@ func (p *Provider) CleanSSH() error {
 	return nil
 }
 
+// ConfigSSH populates ~/.ssh/config entries for the cluster's instances.
+// gcloud's `compute config-ssh` has no Compute Engine API equivalent (it's a
+// purely local operation against ~/.ssh/config), so this always shells out.
 func (p *Provider) ConfigSSH() error {
 	args := []string{"compute", "config-ssh", "--project", p.opts.Project, "--quiet"}
 	cmd := exec.Command("gcloud", args...)
@@ -196,119 +316,178 @@ func (p *Provider) ConfigSSH() error {
 	return nil
 }
 
+// mkInstance builds the Compute Engine instance spec shared by every node we
+// create; callers still need to fill in the zone-specific MachineType.
+func (p *Provider) mkInstance(name string, opts vm.CreateOpts, startupScript []byte) *compute.Instance {
+	instance := &compute.Instance{
+		Name: name,
+		Disks: []*compute.AttachedDisk{
+			{
+				AutoDelete: true,
+				Boot:       true,
+				InitializeParams: &compute.AttachedDiskInitializeParams{
+					SourceImage: "projects/ubuntu-os-cloud/global/images/ubuntu-1604-xenial-v20171002",
+					DiskSizeGb:  10,
+					DiskType:    "pd-ssd",
+					// Mirrors the instance's lifetime label onto the boot
+					// disk so GarbageCollect can still age out the disk if
+					// it's ever detached from its instance.
+					Labels: map[string]string{"lifetime": opts.Lifetime.String()},
+				},
+			},
+		},
+		NetworkInterfaces: []*compute.NetworkInterface{
+			{
+				Network:       "global/networks/default",
+				AccessConfigs: []*compute.AccessConfig{{Type: "ONE_TO_ONE_NAT"}},
+			},
+		},
+		Scheduling: &compute.Scheduling{OnHostMaintenance: "MIGRATE"},
+		Labels:     map[string]string{"lifetime": opts.Lifetime.String()},
+		Metadata: &compute.Metadata{
+			Items: []*compute.MetadataItems{
+				{Key: "startup-script", Value: stringPtr(string(startupScript))},
+			},
+		},
+	}
+	account := p.opts.ServiceAccount
+	scopes := []string{"https://www.googleapis.com/auth/cloud-platform"}
+	if account == "" {
+		// Mirrors the gcloud CLI's implicit `--scopes default,storage-rw`,
+		// which every cluster has relied on (e.g. for the startup script to
+		// pull binaries off GCS) regardless of whether a custom service
+		// account was given. "default" tells the API to use the project's
+		// default Compute Engine service account.
+		account = "default"
+		scopes = []string{
+			"https://www.googleapis.com/auth/devstorage.read_write",
+			"https://www.googleapis.com/auth/logging.write",
+			"https://www.googleapis.com/auth/monitoring.write",
+			"https://www.googleapis.com/auth/servicecontrol",
+			"https://www.googleapis.com/auth/service.management.readonly",
+			"https://www.googleapis.com/auth/trace.append",
+		}
+	}
+	instance.ServiceAccounts = []*compute.ServiceAccount{
+		{Email: account, Scopes: scopes},
+	}
+	if opts.UseLocalSSD {
+		instance.Disks = append(instance.Disks, &compute.AttachedDisk{
+			AutoDelete: true,
+			Type:       "SCRATCH",
+			Interface:  "SCSI",
+			InitializeParams: &compute.AttachedDiskInitializeParams{
+				DiskType: "local-ssd",
+			},
+		})
+	}
+	return instance
+}
+
 func (p *Provider) Create(names []string, opts vm.CreateOpts) error {
+	if p.opts.UseGCloud {
+		return p.gcloudCreate(names, opts)
+	}
+
 	if p.opts.Project != defaultProject {
 		fmt.Printf("WARNING: --lifetime functionality requires "+
 			"`roachprod gc --gce-project=%s` cronjob\n", p.opts.Project)
 	}
 
+	service, err := p.computeService()
+	if err != nil {
+		return err
+	}
+
 	// Create GCE startup script file.
 	filename, err := writeStartupScript()
 	if err != nil {
 		return errors.Wrapf(err, "could not write GCE startup script to temp file")
 	}
 	defer os.Remove(filename)
-
-	if !opts.GeoDistributed {
-		p.opts.Zones = []string{p.opts.Zones[0]}
-	}
-
-	totalNodes := float64(len(names))
-	totalZones := float64(len(p.opts.Zones))
-	nodesPerZone := int(math.Ceil(totalNodes / totalZones))
-
-	ct := int(0)
-	i := 0
-
-	// Fixed args.
-	args := []string{
-		"compute", "instances", "create",
-		"--subnet", "default",
-		"--maintenance-policy", "MIGRATE",
-		"--scopes", "default,storage-rw",
-		"--image", "ubuntu-1604-xenial-v20171002",
-		"--image-project", "ubuntu-os-cloud",
-		"--boot-disk-size", "10",
-		"--boot-disk-type", "pd-ssd",
+	startupScript, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return errors.Wrapf(err, "could not read GCE startup script")
 	}
 
 	if p.opts.Project == defaultProject && p.opts.ServiceAccount == "" {
 		p.opts.ServiceAccount = "21965078311-compute@developer.gserviceaccount.com"
 	}
-	if p.opts.ServiceAccount != "" {
-		args = append(args, "--service-account", p.opts.ServiceAccount)
-	}
 
-	// Dynamic args.
-	if opts.UseLocalSSD {
-		args = append(args, "--local-ssd", "interface=SCSI")
+	if !opts.GeoDistributed {
+		p.opts.Zones = []string{p.opts.Zones[0]}
 	}
-	args = append(args, "--machine-type", p.opts.MachineType)
-	args = append(args, "--labels", fmt.Sprintf("lifetime=%s", opts.Lifetime))
 
-	args = append(args, "--metadata-from-file", fmt.Sprintf("startup-script=%s", filename))
-	args = append(args, "--project", p.opts.Project)
+	totalNodes := float64(len(names))
+	totalZones := float64(len(p.opts.Zones))
+	nodesPerZone := int(math.Ceil(totalNodes / totalZones))
 
 	var g errgroup.Group
 
+	ct := 0
+	i := 0
 	// This is calculating the number of machines to allocate per zone by taking the ceiling of the the total number
 	// of machines left divided by the number of zones left. If the the number of machines isn't
 	// divisible by the number of zones, then the extra machines will be allocated one per zone until there are
 	// no more extra machines left.
 	for i < len(names) {
-		argsWithZone := append(args[:len(args):len(args)], "--zone", p.opts.Zones[ct])
+		zone := p.opts.Zones[ct]
+		zoneNames := names[i : i+nodesPerZone]
 		ct++
-		argsWithZone = append(argsWithZone, names[i:i+nodesPerZone]...)
 		i += nodesPerZone
 
 		totalNodes -= float64(nodesPerZone)
-		totalZones -= 1
+		totalZones--
 		nodesPerZone = int(math.Ceil(totalNodes / totalZones))
 
-		g.Go(func() error {
-			cmd := exec.Command("gcloud", argsWithZone...)
-
-			output, err := cmd.CombinedOutput()
-			if err != nil {
-				return errors.Wrapf(err, "Command: gcloud %s\nOutput: %s", args, output)
-			}
-			return nil
-		})
-
+		for _, name := range zoneNames {
+			name, zone := name, zone
+			g.Go(func() error {
+				instance := p.mkInstance(name, opts, startupScript)
+				instance.MachineType = fmt.Sprintf("zones/%s/machineTypes/%s", zone, p.opts.MachineType)
+
+				var op *compute.Operation
+				if err := withRetry(func() error {
+					var insertErr error
+					op, insertErr = service.Instances.Insert(p.opts.Project, zone, instance).Do()
+					return insertErr
+				}); err != nil {
+					return errors.Wrapf(err, "creating instance %s in zone %s", name, zone)
+				}
+				return waitForZoneOp(service, p.opts.Project, zone, op)
+			})
+		}
 	}
 
 	return g.Wait()
 }
 
 func (p *Provider) Delete(vms vm.List) error {
-	zoneMap := make(map[string][]string)
-	for _, v := range vms {
-		if v.Provider != ProviderName {
-			return errors.Errorf("%s received VM instance from %s", ProviderName, v.Provider)
-		}
-		zoneMap[v.Zone] = append(zoneMap[v.Zone], v.Name)
+	if p.opts.UseGCloud {
+		return p.gcloudDelete(vms)
 	}
 
-	var g errgroup.Group
+	service, err := p.computeService()
+	if err != nil {
+		return err
+	}
 
-	for zone, names := range zoneMap {
-		args := []string{
-			"compute", "instances", "delete",
-			"--delete-disks", "all",
+	var g errgroup.Group
+	for _, v := range vms {
+		if v.Provider != ProviderName {
+			return errors.Errorf("%s received VM instance from %s", ProviderName, v.Provider)
 		}
-
-		args = append(args, "--project", p.opts.Project)
-		args = append(args, "--zone", zone)
-		args = append(args, names...)
-
+		v := v
 		g.Go(func() error {
-			cmd := exec.Command("gcloud", args...)
-
-			output, err := cmd.CombinedOutput()
-			if err != nil {
-				return errors.Wrapf(err, "Command: gcloud %s\nOutput: %s", args, output)
+			var op *compute.Operation
+			if err := withRetry(func() error {
+				var deleteErr error
+				op, deleteErr = service.Instances.Delete(p.opts.Project, v.Zone, v.Name).Do()
+				return deleteErr
+			}); err != nil {
+				return errors.Wrapf(err, "deleting instance %s in zone %s", v.Name, v.Zone)
 			}
-			return nil
+			return waitForZoneOp(service, p.opts.Project, v.Zone, op)
 		})
 	}
 
@@ -316,70 +495,247 @@ func (p *Provider) Delete(vms vm.List) error {
 }
 
 func (p *Provider) Extend(vms vm.List, lifetime time.Duration) error {
-	// The gcloud command only takes a single instance.  Unlike Delete() above, we have to
-	// perform the iteration here.
+	if p.opts.UseGCloud {
+		return p.gcloudExtend(vms, lifetime)
+	}
+
+	service, err := p.computeService()
+	if err != nil {
+		return err
+	}
+
+	// Unlike Delete() above, the label-fingerprint dance below has to be
+	// performed per-instance, so we iterate serially here.
 	for _, v := range vms {
-		args := []string{"compute", "instances", "add-labels"}
+		instance, err := service.Instances.Get(p.opts.Project, v.Zone, v.Name).Do()
+		if err != nil {
+			return errors.Wrapf(err, "fetching instance %s in zone %s", v.Name, v.Zone)
+		}
 
-		args = append(args, "--project", p.opts.Project)
-		args = append(args, "--zone", v.Zone)
-		args = append(args, "--labels", fmt.Sprintf("lifetime=%s", lifetime))
-		args = append(args, v.Name)
+		labels := instance.Labels
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels["lifetime"] = lifetime.String()
 
-		cmd := exec.Command("gcloud", args...)
+		req := &compute.InstancesSetLabelsRequest{
+			Labels:           labels,
+			LabelFingerprint: instance.LabelFingerprint,
+		}
 
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			return errors.Wrapf(err, "Command: gcloud %s\nOutput: %s", args, output)
+		var op *compute.Operation
+		if err := withRetry(func() error {
+			var setErr error
+			op, setErr = service.Instances.SetLabels(p.opts.Project, v.Zone, v.Name, req).Do()
+			return setErr
+		}); err != nil {
+			return errors.Wrapf(err, "extending instance %s in zone %s", v.Name, v.Zone)
+		}
+		if err := waitForZoneOp(service, p.opts.Project, v.Zone, op); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// FindActiveAccount determines the identity the provider will authenticate
+// as, by consulting the same vm.CredentialProvider that computeService()
+// authenticates with.
 func (p *Provider) FindActiveAccount() (string, error) {
-	args := []string{"auth", "list", "--format", "json", "--filter", "status~ACTIVE"}
+	if p.opts.UseGCloud {
+		return p.gcloudFindActiveAccount()
+	}
 
-	accounts := make([]jsonAuth, 0)
-	if err := runJSONCommand(args, &accounts); err != nil {
+	cp, err := p.credentials()
+	if err != nil {
 		return "", err
 	}
-
-	if len(accounts) != 1 {
-		return "", fmt.Errorf("no active accounts found, please configure gcloud")
+	email := cp.Account()
+	if email == "" {
+		return "", errors.New("credential provider did not report an account email")
 	}
-
-	if !strings.HasSuffix(accounts[0].Account, config.EmailDomain) {
-		return "", fmt.Errorf("active account %q does no belong to domain %s",
-			accounts[0].Account, config.EmailDomain)
+	if !strings.HasSuffix(email, config.EmailDomain) {
+		return "", fmt.Errorf("active account %q does no belong to domain %s", email, config.EmailDomain)
 	}
-
-	username := strings.Split(accounts[0].Account, "@")[0]
-	return username, nil
+	return strings.Split(email, "@")[0], nil
 }
 
 func (p *Provider) Flags() vm.ProviderFlags {
 	return &p.opts
 }
 
-// Query gcloud to produce a list of VM info objects.
+// maxConcurrentZoneListers bounds how many zones List/ListAllZones scan at
+// once, so a project with hundreds of zones doesn't open hundreds of
+// concurrent API calls.
+const maxConcurrentZoneListers = 16
+
+// listZones returns the zones List should scan: just --gce-zones, if the
+// user set it explicitly, otherwise every zone in the project. The latter
+// finds instances in a project without knowing ahead of time which zones a
+// cluster used.
+func (p *Provider) listZones(service *compute.Service) ([]string, error) {
+	if p.opts.zonesFlag != nil && p.opts.zonesFlag.Changed {
+		return p.opts.Zones, nil
+	}
+
+	var zones []string
+	err := service.Zones.List(p.opts.Project).Pages(context.Background(), func(page *compute.ZoneList) error {
+		for _, zone := range page.Items {
+			zones = append(zones, zone.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "listing zones")
+	}
+	return zones, nil
+}
+
+// listZonesConcurrently fans Instances.List out across zones, bounded by
+// maxConcurrentZoneListers, and aggregates the results and any per-zone
+// errors so that one unreachable zone doesn't fail discovery in the rest.
+func (p *Provider) listZonesConcurrently(
+	service *compute.Service, zones []string,
+) (vm.List, map[string]error) {
+	type zoneResult struct {
+		zone string
+		vms  vm.List
+		err  error
+	}
+
+	results := make(chan zoneResult, len(zones))
+	sem := make(chan struct{}, maxConcurrentZoneListers)
+
+	var wg sync.WaitGroup
+	for _, zone := range zones {
+		zone := zone
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			var zoneVMs vm.List
+			err := service.Instances.List(p.opts.Project, zone).Pages(context.Background(),
+				func(page *compute.InstanceList) error {
+					for _, instance := range page.Items {
+						zoneVMs = append(zoneVMs, *instanceToVM(p.opts.Project, instance))
+					}
+					return nil
+				})
+			results <- zoneResult{zone: zone, vms: zoneVMs, err: err}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var vms vm.List
+	zoneErrs := map[string]error{}
+	for res := range results {
+		if res.err != nil {
+			zoneErrs[res.zone] = res.err
+			continue
+		}
+		vms = append(vms, res.vms...)
+	}
+	return vms, zoneErrs
+}
+
+// List queries the Compute Engine API to produce a list of VM info objects.
 func (p *Provider) List() (vm.List, error) {
-	args := []string{"compute", "instances", "list", "--project", p.opts.Project, "--format", "json"}
+	if p.opts.UseGCloud {
+		return p.gcloudList()
+	}
 
-	// Run the command, extracting the JSON payload
-	jsonVMS := make([]jsonVM, 0)
-	if err := runJSONCommand(args, &jsonVMS); err != nil {
+	service, err := p.computeService()
+	if err != nil {
 		return nil, err
 	}
 
-	// Now, convert the json payload into our common VM type
-	vms := make(vm.List, len(jsonVMS))
-	for i, jsonVM := range jsonVMS {
-		vms[i] = *jsonVM.toVM(p.opts.Project)
+	zones, err := p.listZones(service)
+	if err != nil {
+		return nil, err
 	}
 
+	vms, zoneErrs := p.listZonesConcurrently(service, zones)
+	if len(vms) == 0 && len(zoneErrs) > 0 {
+		return nil, errors.Errorf("listing instances failed in all %d zone(s): %v", len(zoneErrs), zoneErrs)
+	}
+	for zone, zerr := range zoneErrs {
+		fmt.Printf("gce: failed to list instances in zone %s: %s\n", zone, zerr)
+	}
 	return vms, nil
 }
 
+// ListAllZones is like List, but always discovers every zone in the project
+// (ignoring --gce-zones) and surfaces per-zone errors individually instead
+// of collapsing them into one, so a caller can report exactly where
+// discovery failed.
+func (p *Provider) ListAllZones() (vm.List, map[string]error, error) {
+	service, err := p.computeService()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var zones []string
+	err = service.Zones.List(p.opts.Project).Pages(context.Background(), func(page *compute.ZoneList) error {
+		for _, zone := range page.Items {
+			zones = append(zones, zone.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "listing zones")
+	}
+
+	vms, zoneErrs := p.listZonesConcurrently(service, zones)
+	return vms, zoneErrs, nil
+}
+
+// AnyInstanceInProject reports, as fast as possible, whether the project has
+// any instance at all. It races a 1-result Instances.List across the zones
+// listZones returns (only --gce-zones, if that was set explicitly) and
+// returns as soon as the first zone reports something (or every zone has come
+// up empty or failed), rather than waiting for a full scan.
+func (p *Provider) AnyInstanceInProject() (bool, error) {
+	service, err := p.computeService()
+	if err != nil {
+		return false, err
+	}
+	zones, err := p.listZones(service)
+	if err != nil {
+		return false, err
+	}
+
+	var found int32
+	done := make(chan struct{})
+	var once sync.Once
+	var wg sync.WaitGroup
+
+	for _, zone := range zones {
+		zone := zone
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			list, err := service.Instances.List(p.opts.Project, zone).MaxResults(1).Do()
+			if err != nil || list == nil || len(list.Items) == 0 {
+				return
+			}
+			atomic.StoreInt32(&found, 1)
+			once.Do(func() { close(done) })
+		}()
+	}
+	go func() {
+		wg.Wait()
+		once.Do(func() { close(done) })
+	}()
+
+	<-done
+	return atomic.LoadInt32(&found) == 1, nil
+}
+
 func (p *Provider) Name() string {
 	return ProviderName
 }